@@ -0,0 +1,134 @@
+package tarball
+
+import (
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+
+	dsnetbzip2 "github.com/dsnet/compress/bzip2"
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// Compression identifies the codec a tarball stream is wrapped in.
+type Compression int
+
+const (
+	Uncompressed Compression = iota
+	Gzip
+	Bzip2
+	Xz
+	Zstd
+)
+
+func (c Compression) String() string {
+	switch c {
+	case Uncompressed:
+		return "none"
+	case Gzip:
+		return "gzip"
+	case Bzip2:
+		return "bzip2"
+	case Xz:
+		return "xz"
+	case Zstd:
+		return "zstd"
+	default:
+		return "unknown"
+	}
+}
+
+// magicNumbers are checked in order against the start of a stream; the first
+// match wins.
+var magicNumbers = []struct {
+	compression Compression
+	magic       []byte
+}{
+	{Gzip, []byte{0x1f, 0x8b}},
+	{Bzip2, []byte{0x42, 0x5a, 0x68}},
+	{Xz, []byte{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00}},
+	{Zstd, []byte{0x28, 0xb5, 0x2f, 0xfd}},
+}
+
+// DecompressStream peeks at the first few bytes of r to identify its
+// compression codec, then returns a reader that transparently decompresses
+// the whole stream (peeked bytes included) along with the codec it detected.
+// If no magic number matches, the stream is assumed to be an uncompressed
+// tarball.
+func DecompressStream(r io.Reader) (io.ReadCloser, Compression, error) {
+	peek := make([]byte, 6)
+	n, err := io.ReadFull(r, peek)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, Uncompressed, err
+	}
+	peek = peek[:n]
+
+	rest := io.MultiReader(bytes.NewReader(peek), r)
+
+	compression := Uncompressed
+	for _, m := range magicNumbers {
+		if bytes.HasPrefix(peek, m.magic) {
+			compression = m.compression
+			break
+		}
+	}
+
+	switch compression {
+	case Gzip:
+		gzipReader, err := gzip.NewReader(rest)
+		if err != nil {
+			return nil, compression, err
+		}
+		return gzipReader, compression, nil
+	case Bzip2:
+		return io.NopCloser(bzip2.NewReader(rest)), compression, nil
+	case Xz:
+		xzReader, err := xz.NewReader(rest)
+		if err != nil {
+			return nil, compression, err
+		}
+		return io.NopCloser(xzReader), compression, nil
+	case Zstd:
+		zstdReader, err := zstd.NewReader(rest)
+		if err != nil {
+			return nil, compression, err
+		}
+		return zstdReader.IOReadCloser(), compression, nil
+	default:
+		return io.NopCloser(rest), compression, nil
+	}
+}
+
+// NewCompressedWriter wraps w so that everything written to the result is
+// compressed with c. level is interpreted the same way as compress/gzip's
+// levels for Gzip; it is ignored by codecs that don't support tunable
+// compression levels.
+func NewCompressedWriter(w io.Writer, c Compression, level int) (io.WriteCloser, error) {
+	switch c {
+	case Uncompressed:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriterLevel(w, level)
+	case Bzip2:
+		// The bzip2 writer only accepts levels 1-9, with 0 selecting its own
+		// default, so gzip.DefaultCompression is mapped onto that default.
+		if level == gzip.DefaultCompression {
+			level = 0
+		}
+		return dsnetbzip2.NewWriter(w, &dsnetbzip2.WriterConfig{Level: level})
+	case Xz:
+		return xz.NewWriter(w)
+	case Zstd:
+		return zstd.NewWriter(w, zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(level)))
+	default:
+		return nil, fmt.Errorf("unsupported compression: %s", c)
+	}
+}
+
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
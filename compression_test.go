@@ -0,0 +1,56 @@
+package tarball
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestDecompressStreamDetectsCodecByMagicBytes(t *testing.T) {
+	cases := []struct {
+		name string
+		c    Compression
+	}{
+		{"gzip", Gzip},
+		{"bzip2", Bzip2},
+		{"xz", Xz},
+		{"zstd", Zstd},
+		{"none", Uncompressed},
+	}
+
+	want := []byte("hello, tarball")
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := new(bytes.Buffer)
+			writer, err := NewCompressedWriter(buf, tc.c, 1)
+			if err != nil {
+				t.Fatalf("failed to create compressed writer: %s", err)
+			}
+			if _, err := writer.Write(want); err != nil {
+				t.Fatalf("failed to write: %s", err)
+			}
+			if err := writer.Close(); err != nil {
+				t.Fatalf("failed to close writer: %s", err)
+			}
+
+			reader, detected, err := DecompressStream(bytes.NewReader(buf.Bytes()))
+			if err != nil {
+				t.Fatalf("failed to decompress stream: %s", err)
+			}
+			defer reader.Close()
+
+			if detected != tc.c {
+				t.Fatalf("detected compression %s, want %s", detected, tc.c)
+			}
+
+			got, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("failed to read decompressed stream: %s", err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Fatalf("decompressed content %q, want %q", got, want)
+			}
+		})
+	}
+}
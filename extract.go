@@ -0,0 +1,168 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sanitizeEntry validates that header describes a path which, once joined
+// onto dest, cannot escape dest — guarding against zip-slip style archives
+// that use ".." segments, absolute paths, or symlink/hardlink targets to
+// write outside the intended extraction root. It returns the cleaned path
+// (dest joined with the entry name) the entry should be written to; it is
+// absolute only when dest is.
+func sanitizeEntry(dest string, header *tar.Header) (string, error) {
+	cleanDest := filepath.Clean(dest)
+
+	if filepath.IsAbs(header.Name) {
+		return "", fmt.Errorf("tarball entry %q has an absolute path", header.Name)
+	}
+
+	cleanPath, err := joinAndVerify(cleanDest, header.Name)
+	if err != nil {
+		return "", err
+	}
+
+	switch header.Typeflag {
+	case tar.TypeSymlink:
+		if filepath.IsAbs(header.Linkname) {
+			return "", fmt.Errorf("tarball entry %q links to absolute path %q", header.Name, header.Linkname)
+		}
+		// Symlinks are resolved relative to their own directory, same as the
+		// OS does when the link is followed after extraction.
+		if _, err := joinAndVerify(cleanDest, filepath.Join(filepath.Dir(header.Name), header.Linkname)); err != nil {
+			return "", fmt.Errorf("tarball entry %q links outside destination: %s", header.Name, err)
+		}
+	case tar.TypeLink:
+		if filepath.IsAbs(header.Linkname) {
+			return "", fmt.Errorf("tarball entry %q links to absolute path %q", header.Name, header.Linkname)
+		}
+		// Hardlinks are created via os.Link against a path resolved relative
+		// to the extraction root (see ExtractTarball), not the entry's
+		// directory, so validate against that same base.
+		if _, err := joinAndVerify(cleanDest, header.Linkname); err != nil {
+			return "", fmt.Errorf("tarball entry %q links outside destination: %s", header.Name, err)
+		}
+	}
+
+	return cleanPath, nil
+}
+
+// joinAndVerify joins name onto dest and verifies the cleaned result is
+// still rooted at dest, rejecting any ".." escape.
+func joinAndVerify(dest, name string) (string, error) {
+	joined := filepath.Join(dest, name)
+	if joined != dest && !strings.HasPrefix(joined, dest+string(os.PathSeparator)) {
+		return "", fmt.Errorf("%q escapes destination %q", name, dest)
+	}
+	return joined, nil
+}
+
+// UnsupportedTypeflagPolicy controls how ExtractTarball reacts to archive
+// entries it doesn't know how to materialize on disk (device nodes, FIFOs,
+// etc).
+type UnsupportedTypeflagPolicy int
+
+const (
+	// ErrorOnUnsupportedTypeflag fails extraction on the first unsupported entry.
+	ErrorOnUnsupportedTypeflag UnsupportedTypeflagPolicy = iota
+	// SkipUnsupportedTypeflag silently ignores unsupported entries.
+	SkipUnsupportedTypeflag
+)
+
+// ExtractOptions controls ExtractTarball's behavior.
+type ExtractOptions struct {
+	// OnUnsupportedTypeflag decides what happens when an entry's Typeflag
+	// isn't a regular file, directory, or (sym|hard)link.
+	OnUnsupportedTypeflag UnsupportedTypeflagPolicy
+	// Redactors, if set, are applied to every regular file's content before
+	// it's written to disk.
+	Redactors []Redactor
+}
+
+// ExtractTarball safely extracts a (possibly compressed) tarball held in
+// data into destDir, rejecting any entry whose name or link target would
+// escape destDir.
+func ExtractTarball(data []byte, destDir string, opts ExtractOptions) error {
+	decompressed, _, err := DecompressStream(bytes.NewBuffer(data))
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	destDir = filepath.Clean(destDir)
+	if err := os.MkdirAll(destDir, 0755); err != nil {
+		return fmt.Errorf("failed to create destination %s: %s", destDir, err)
+	}
+
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		target, err := sanitizeEntry(destDir, header)
+		if err != nil {
+			return err
+		}
+
+		switch header.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, os.FileMode(header.Mode)); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := extractRegularFile(target, header, tarReader, opts.Redactors); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := os.Symlink(header.Linkname, target); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			linkSrc, err := joinAndVerify(destDir, header.Linkname)
+			if err != nil {
+				return err
+			}
+			if err := os.Link(linkSrc, target); err != nil {
+				return err
+			}
+		default:
+			if opts.OnUnsupportedTypeflag == ErrorOnUnsupportedTypeflag {
+				return fmt.Errorf("tarball entry %q has unsupported typeflag %c", header.Name, header.Typeflag)
+			}
+		}
+	}
+}
+
+func extractRegularFile(target string, header *tar.Header, r io.Reader, redactors []Redactor) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, os.FileMode(header.Mode))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if len(redactors) > 0 {
+		redacted, _, cleanup, err := redactCopy(header.Name, r, redactors)
+		defer cleanup()
+		if err != nil {
+			return err
+		}
+		r = redacted
+	}
+
+	_, err = io.Copy(file, r)
+	return err
+}
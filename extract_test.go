@@ -0,0 +1,103 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestTarball(t *testing.T, entries []tar.Header) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, header := range entries {
+		h := header
+		if err := tarWriter.WriteHeader(&h); err != nil {
+			t.Fatalf("failed to write header: %s", err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %s", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestExtractTarballRejectsZipSlip(t *testing.T) {
+	data := writeTestTarball(t, []tar.Header{
+		{Name: "../escape.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 0},
+	})
+
+	destDir, err := ioutil.TempDir("", "tarball-extract-dest-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	if err := ExtractTarball(data, destDir, ExtractOptions{}); err == nil {
+		t.Fatal("expected ExtractTarball to reject a \"../\" entry, got nil error")
+	}
+}
+
+func TestExtractTarballRejectsSymlinkEscape(t *testing.T) {
+	data := writeTestTarball(t, []tar.Header{
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "../../outside", Mode: 0644},
+	})
+
+	destDir, err := ioutil.TempDir("", "tarball-extract-dest-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	if err := ExtractTarball(data, destDir, ExtractOptions{}); err == nil {
+		t.Fatal("expected ExtractTarball to reject a symlink escaping destDir, got nil error")
+	}
+}
+
+func TestExtractTarballAcceptsWellBehavedEntries(t *testing.T) {
+	buf := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "dir/", Typeflag: tar.TypeDir, Mode: 0755}); err != nil {
+		t.Fatalf("failed to write dir header: %s", err)
+	}
+	if err := tarWriter.WriteHeader(&tar.Header{Name: "dir/file.txt", Typeflag: tar.TypeReg, Mode: 0644, Size: 5}); err != nil {
+		t.Fatalf("failed to write file header: %s", err)
+	}
+	if _, err := tarWriter.Write([]byte("hello")); err != nil {
+		t.Fatalf("failed to write file content: %s", err)
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatalf("failed to close tar writer: %s", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatalf("failed to close gzip writer: %s", err)
+	}
+	data := buf.Bytes()
+
+	destDir, err := ioutil.TempDir("", "tarball-extract-dest-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(destDir)
+
+	if err := ExtractTarball(data, destDir, ExtractOptions{}); err != nil {
+		t.Fatalf("failed to extract well-behaved tarball: %s", err)
+	}
+
+	got, err := ioutil.ReadFile(filepath.Join(destDir, "dir", "file.txt"))
+	if err != nil {
+		t.Fatalf("failed to read extracted file: %s", err)
+	}
+	if string(got) != "hello" {
+		t.Fatalf("extracted content %q, want %q", got, "hello")
+	}
+}
@@ -0,0 +1,228 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"regexp"
+
+	"gopkg.in/yaml.v3"
+)
+
+// spillThreshold is the entry size at or above which redactCopy spills to a
+// temp file instead of buffering in memory.
+const spillThreshold = 32 << 20 // 32 MiB
+
+// Redactor transforms the content of a tar entry before it's written out,
+// returning the replacement content and its (possibly different) size.
+type Redactor interface {
+	Redact(name string, r io.Reader) (io.Reader, int64, error)
+}
+
+// redactCopy runs r through redactors in order and returns the final
+// content along with its size. Built-in redactors always know their output
+// size up front; a custom Redactor that returns a negative size falls back
+// to buffering the result (spilling to a temp file at spillThreshold) so
+// the size can still be determined before the tar header is written.
+func redactCopy(name string, r io.Reader, redactors []Redactor) (io.Reader, int64, func(), error) {
+	noop := func() {}
+	if len(redactors) == 0 {
+		return r, -1, noop, nil
+	}
+
+	size := int64(-1)
+	for _, redactor := range redactors {
+		redacted, n, err := redactor.Redact(name, r)
+		if err != nil {
+			return nil, 0, noop, fmt.Errorf("failed to redact %s: %s", name, err)
+		}
+		r, size = redacted, n
+	}
+
+	if size >= 0 {
+		return r, size, noop, nil
+	}
+
+	buf := new(bytes.Buffer)
+	if _, err := io.CopyN(buf, r, spillThreshold); err != nil && err != io.EOF {
+		return nil, 0, noop, err
+	}
+	if buf.Len() < spillThreshold {
+		return bytes.NewReader(buf.Bytes()), int64(buf.Len()), noop, nil
+	}
+
+	tmp, err := ioutil.TempFile("", "tarball-redact-*")
+	if err != nil {
+		return nil, 0, noop, err
+	}
+	cleanup := func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+	copied, err := io.Copy(tmp, r)
+	if err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+	size = copied + int64(buf.Len())
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		cleanup()
+		return nil, 0, noop, err
+	}
+	return tmp, size, cleanup, nil
+}
+
+// writeEntryWithRedaction writes header to tw and copies entry's content,
+// running it through redactors first when header describes a regular file.
+// Since redaction can change an entry's size, the header's Size is rewritten
+// to match before it's written out.
+func writeEntryWithRedaction(tw *tar.Writer, header *tar.Header, entry io.Reader, redactors []Redactor) error {
+	if header.Typeflag != tar.TypeReg || len(redactors) == 0 {
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err := io.Copy(tw, entry)
+		return err
+	}
+
+	redacted, size, cleanup, err := redactCopy(header.Name, entry, redactors)
+	defer cleanup()
+	if err != nil {
+		return err
+	}
+	if size >= 0 {
+		header.Size = size
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err = io.Copy(tw, redacted)
+	return err
+}
+
+// RegexRedactor replaces any text matching Pattern with Replacement,
+// operating over an entry's full contents (passed to regexp.Regexp.ReplaceAll
+// semantics) so line terminators and binary data pass through untouched.
+type RegexRedactor struct {
+	Pattern     *regexp.Regexp
+	Replacement []byte
+}
+
+// NewRegexRedactor builds a RegexRedactor from a regular expression.
+func NewRegexRedactor(pattern, replacement string) *RegexRedactor {
+	return &RegexRedactor{
+		Pattern:     regexp.MustCompile(pattern),
+		Replacement: []byte(replacement),
+	}
+}
+
+func (rr *RegexRedactor) Redact(name string, r io.Reader) (io.Reader, int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	out := rr.Pattern.ReplaceAll(data, rr.Replacement)
+	return bytes.NewReader(out), int64(len(out)), nil
+}
+
+// AWSAccessKeyRedactor replaces AWS access key IDs (AKIA.../ASIA...) with a
+// fixed placeholder.
+func AWSAccessKeyRedactor() *RegexRedactor {
+	return NewRegexRedactor(`(AKIA|ASIA)[0-9A-Z]{16}`, "[REDACTED-AWS-KEY]")
+}
+
+// JWTRedactor replaces bearer-style JWTs with a fixed placeholder.
+func JWTRedactor() *RegexRedactor {
+	return NewRegexRedactor(`eyJ[A-Za-z0-9_-]+\.eyJ[A-Za-z0-9_-]+\.[A-Za-z0-9_-]+`, "[REDACTED-JWT]")
+}
+
+// PasswordQueryParamRedactor replaces `password=...` (and similarly named)
+// query-string values with a fixed placeholder.
+func PasswordQueryParamRedactor() *RegexRedactor {
+	return NewRegexRedactor(`(?i)(password|passwd|secret|token)=[^&\s]+`, "$1=[REDACTED]")
+}
+
+// KeyNameRedactor walks a structured document (YAML or JSON, selected by
+// Format) and replaces the values of any key matching one of Keys.
+type KeyNameRedactor struct {
+	Keys        map[string]bool
+	Replacement string
+	// Format is "yaml" or "json".
+	Format string
+}
+
+// NewKeyNameRedactor builds a KeyNameRedactor matching the given key names
+// (case-sensitive) in documents of the given format ("yaml" or "json").
+func NewKeyNameRedactor(format string, keys ...string) *KeyNameRedactor {
+	keySet := make(map[string]bool, len(keys))
+	for _, k := range keys {
+		keySet[k] = true
+	}
+	return &KeyNameRedactor{Keys: keySet, Replacement: "[REDACTED]", Format: format}
+}
+
+func (kr *KeyNameRedactor) Redact(name string, r io.Reader) (io.Reader, int64, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var doc interface{}
+	switch kr.Format {
+	case "json":
+		if err := json.Unmarshal(data, &doc); err != nil {
+			return bytes.NewReader(data), int64(len(data)), nil
+		}
+		kr.redactValue(doc)
+		out, err := json.Marshal(doc)
+		if err != nil {
+			return nil, 0, err
+		}
+		return bytes.NewReader(out), int64(len(out)), nil
+	case "yaml":
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return bytes.NewReader(data), int64(len(data)), nil
+		}
+		kr.redactValue(doc)
+		out, err := yaml.Marshal(doc)
+		if err != nil {
+			return nil, 0, err
+		}
+		return bytes.NewReader(out), int64(len(out)), nil
+	default:
+		return nil, 0, fmt.Errorf("unknown KeyNameRedactor format %q", kr.Format)
+	}
+}
+
+func (kr *KeyNameRedactor) redactValue(v interface{}) {
+	switch t := v.(type) {
+	case map[string]interface{}:
+		for k, val := range t {
+			if kr.Keys[k] {
+				t[k] = kr.Replacement
+				continue
+			}
+			kr.redactValue(val)
+		}
+	case map[interface{}]interface{}:
+		for k, val := range t {
+			if ks, ok := k.(string); ok && kr.Keys[ks] {
+				t[k] = kr.Replacement
+				continue
+			}
+			kr.redactValue(val)
+		}
+	case []interface{}:
+		for _, item := range t {
+			kr.redactValue(item)
+		}
+	}
+}
@@ -0,0 +1,12 @@
+//go:build windows
+// +build windows
+
+package tarball
+
+import "os"
+
+// fileOwner has no portable equivalent on Windows, so uid/gid/names are left
+// at their zero values.
+func fileOwner(info os.FileInfo) (uid, gid int, uname, gname string) {
+	return 0, 0, "", ""
+}
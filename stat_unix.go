@@ -0,0 +1,31 @@
+//go:build !windows
+// +build !windows
+
+package tarball
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"syscall"
+)
+
+// fileOwner extracts the uid/gid (and, when resolvable, the owning user/group
+// names) of a file from its underlying syscall.Stat_t.
+func fileOwner(info os.FileInfo) (uid, gid int, uname, gname string) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return 0, 0, "", ""
+	}
+
+	uid = int(stat.Uid)
+	gid = int(stat.Gid)
+
+	if u, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		uname = u.Username
+	}
+	if g, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		gname = g.Name
+	}
+	return uid, gid, uname, gname
+}
@@ -0,0 +1,139 @@
+package tarball
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// CombineTarballsTo is the streaming counterpart of CombineTarballs: it
+// concatenates the tar entries of readers into a single gzipped tarball
+// written directly to w, without buffering the result in memory.
+func CombineTarballsTo(w io.Writer, readers []io.Reader) error {
+	return CombineTarballsToWithRedactors(w, readers, nil)
+}
+
+// CombineTarballsToWithRedactors is CombineTarballsTo with an optional set of
+// Redactors applied to every regular file's content as it's copied over.
+func CombineTarballsToWithRedactors(w io.Writer, readers []io.Reader, redactors []Redactor) error {
+	gzipWriter, err := NewCompressedWriter(w, Gzip, gzip.DefaultCompression)
+	if err != nil {
+		return err
+	}
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	for _, reader := range readers {
+		err := func() error {
+			decompressed, _, err := DecompressStream(reader)
+			if err != nil {
+				return err
+			}
+			defer decompressed.Close()
+
+			tarReader := tar.NewReader(decompressed)
+			for {
+				header, err := tarReader.Next()
+				if err != nil {
+					if err == io.EOF {
+						return nil
+					}
+					return err
+				}
+
+				if err = writeEntryWithRedaction(tarWriter, header, tarReader, redactors); err != nil {
+					return err
+				}
+			}
+		}()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	return gzipWriter.Close()
+}
+
+// tarEntryReader reads a single tar entry's contents while keeping the
+// underlying decompressor alive until Close is called.
+type tarEntryReader struct {
+	entry        io.Reader
+	decompressed io.Closer
+}
+
+func (t *tarEntryReader) Read(p []byte) (int, error) {
+	return t.entry.Read(p)
+}
+
+func (t *tarEntryReader) Close() error {
+	return t.decompressed.Close()
+}
+
+// ReadFileFromTarballStream scans the (possibly compressed) tarball read
+// from r for an entry named path and returns a reader over its contents.
+// Closing the returned reader also closes the underlying decompressor.
+// Unlike ReadFileFromGzippedTarball, the whole tarball is never buffered in
+// memory.
+func ReadFileFromTarballStream(r io.Reader, path string) (io.ReadCloser, error) {
+	decompressed, _, err := DecompressStream(r)
+	if err != nil {
+		return nil, err
+	}
+
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			decompressed.Close()
+			if err == io.EOF {
+				err = fmt.Errorf("no file named %s in tarball", path)
+			}
+			return nil, err
+		}
+		if header.Name == path {
+			return &tarEntryReader{entry: tarReader, decompressed: decompressed}, nil
+		}
+	}
+}
+
+// WriteTarballStreamToTarWriter is the streaming counterpart of
+// WriteTarballToTarWriter: it reads a (possibly compressed) tarball from r
+// and re-emits its entries into tw, trimming pathPrefixToTrim from each
+// entry's name, without buffering r in memory.
+func WriteTarballStreamToTarWriter(r io.Reader, tw *tar.Writer, pathPrefixToTrim string) error {
+	return WriteTarballStreamToTarWriterWithRedactors(r, tw, pathPrefixToTrim, nil)
+}
+
+// WriteTarballStreamToTarWriterWithRedactors is WriteTarballStreamToTarWriter
+// with an optional set of Redactors applied to every regular file's content
+// as it's copied over.
+func WriteTarballStreamToTarWriterWithRedactors(r io.Reader, tw *tar.Writer, pathPrefixToTrim string, redactors []Redactor) error {
+	decompressed, _, err := DecompressStream(r)
+	if err != nil {
+		return err
+	}
+	defer decompressed.Close()
+
+	tarReader := tar.NewReader(decompressed)
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if pathPrefixToTrim != "" {
+			header.Name = strings.TrimPrefix(header.Name, pathPrefixToTrim)
+		}
+
+		if err = writeEntryWithRedaction(tw, header, tarReader, redactors); err != nil {
+			return err
+		}
+	}
+}
@@ -10,60 +10,90 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
+// CombineTarballs concatenates the tar entries of readers into a single
+// gzipped tarball. It buffers the whole result in memory; for large bundles
+// use CombineTarballsTo instead.
 func CombineTarballs(readers []io.Reader) ([]byte, error) {
-	buf := new(bytes.Buffer)
-	gzipWriter := gzip.NewWriter(buf)
-	tarWriter := tar.NewWriter(gzipWriter)
+	return CombineTarballsWithRedactors(readers, nil)
+}
 
-	for _, reader := range readers {
-		err := func() error {
-			gzipReader, err := gzip.NewReader(reader)
-			if err != nil {
-				return err
-			}
-			defer gzipReader.Close()
-
-			tarReader := tar.NewReader(gzipReader)
-			for {
-				header, err := tarReader.Next()
-				if err != nil {
-					if err == io.EOF {
-						return nil
-					}
-					return err
-				}
+// CombineTarballsWithRedactors is CombineTarballs with an optional set of
+// Redactors applied to every regular file's content as it's copied over.
+func CombineTarballsWithRedactors(readers []io.Reader, redactors []Redactor) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := CombineTarballsToWithRedactors(buf, readers, redactors); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
 
-				if err = tarWriter.WriteHeader(header); err != nil {
-					return err
-				}
+// WalkOptions controls how CreateGzippedTarballWithOptions walks the source
+// tree and maps it onto archive entries.
+type WalkOptions struct {
+	// Dereference follows symlinks and archives the target's content instead
+	// of recording a TypeSymlink entry.
+	Dereference bool
+	// IncludeHidden includes files and directories whose base name starts
+	// with a dot. Hidden directories are skipped entirely when false.
+	IncludeHidden bool
+	// PathPrefix, if set, is prepended to every entry name inside the
+	// archive (e.g. "bundle" turns "foo/bar" into "bundle/foo/bar").
+	PathPrefix string
+	// Deterministic strips every source of run-to-run variance from
+	// entry headers (timestamps, ownership, mode bits) so that archiving
+	// the same tree twice produces byte-identical output.
+	Deterministic bool
+	// Epoch clamps every entry's ModTime when Deterministic is set. If
+	// zero, it defaults to the SOURCE_DATE_EPOCH environment variable (or
+	// the Unix epoch if that's unset or unparseable).
+	Epoch time.Time
+	// Compression selects the codec the tarball is wrapped in. Zero value
+	// (Uncompressed) is treated as Gzip for backwards compatibility.
+	Compression Compression
+	// Level is passed to NewCompressedWriter; see its docs for how each
+	// codec interprets it. Zero value is treated as gzip.DefaultCompression.
+	Level int
+}
 
-				if _, err = io.Copy(tarWriter, tarReader); err != nil {
-					return err
-				}
-			}
-		}()
-		if err != nil {
-			return nil, err
+// defaultEpoch returns SOURCE_DATE_EPOCH (https://reproducible-builds.org/specs/source-date-epoch/)
+// parsed as a Unix timestamp, or the Unix epoch if it's unset or invalid.
+func defaultEpoch() time.Time {
+	if raw := os.Getenv("SOURCE_DATE_EPOCH"); raw != "" {
+		if seconds, err := strconv.ParseInt(raw, 10, 64); err == nil {
+			return time.Unix(seconds, 0).UTC()
 		}
 	}
-
-	tarWriter.Close()
-	gzipWriter.Close()
-	return buf.Bytes(), nil
+	return time.Unix(0, 0).UTC()
 }
 
 // Create a gzipped tarball of a given path (regular file or directory).
 // If removeFiles is true, this method removes the given files after the tarball
 // is created.
 func CreateGzippedTarball(tarFilePath string, path string, removeFiles bool) error {
-	info, err := os.Stat(path)
+	return CreateGzippedTarballWithOptions(tarFilePath, path, removeFiles, WalkOptions{})
+}
+
+// CreateGzippedTarballWithOptions is CreateGzippedTarball with control over
+// symlink handling, hidden files, an in-archive path prefix, and (despite the
+// name) the wrapping codec and compression level via opts.Compression and
+// opts.Level, which default to Gzip and gzip.DefaultCompression when unset.
+// It walks the full tree rooted at path (via filepath.Walk), so nested
+// directories, symlinks, device files, and empty directories all round-trip.
+func CreateGzippedTarballWithOptions(tarFilePath string, path string, removeFiles bool, opts WalkOptions) error {
+	info, err := os.Lstat(path)
 	if err != nil {
 		return fmt.Errorf("failed to get file info of %s: %s", path, err)
 	}
 
+	if opts.Deterministic && opts.Epoch.IsZero() {
+		opts.Epoch = defaultEpoch()
+	}
+
 	tarballDir := filepath.Dir(tarFilePath)
 	if err := os.MkdirAll(tarballDir, 0700); err != nil {
 		return fmt.Errorf("failed to create parent paths for %s: %s",
@@ -76,38 +106,80 @@ func CreateGzippedTarball(tarFilePath string, path string, removeFiles bool) err
 	}
 	defer file.Close()
 
-	gzipWriter := gzip.NewWriter(file)
-	defer gzipWriter.Close()
+	compression := opts.Compression
+	if compression == Uncompressed {
+		compression = Gzip
+	}
+	level := opts.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
 
-	tarWriter := tar.NewWriter(gzipWriter)
+	compWriter, err := NewCompressedWriter(file, compression, level)
+	if err != nil {
+		return fmt.Errorf("failed to create compressor for %s: %s", tarFilePath, err)
+	}
+	defer compWriter.Close()
+
+	tarWriter := tar.NewWriter(compWriter)
 	defer tarWriter.Close()
 
-	var filePaths []string
-	if !info.IsDir() {
-		filePaths = []string{path}
-	} else {
-		filePaths, err = filepath.Glob(path + "/*")
+	dirOfPath := filepath.Dir(path)
+	if info.IsDir() {
+		err = filepath.Walk(path, func(walkPath string, walkInfo os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+			if walkPath == path {
+				return nil
+			}
+			if walkPath == tarFilePath {
+				return nil
+			}
+			if !opts.IncludeHidden && isHidden(walkPath) {
+				if walkInfo.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			return addFileToTarWriter(walkPath, dirOfPath, tarWriter, opts)
+		})
 		if err != nil {
-			return fmt.Errorf("failed to get all files under %s: %s", path, err)
+			return fmt.Errorf("failed to walk %s: %s", path, err)
 		}
+	} else if err := addFileToTarWriter(path, dirOfPath, tarWriter, opts); err != nil {
+		return fmt.Errorf("failed to add %s to tar writer: %s", path, err)
 	}
 
-	dirOfPath := filepath.Dir(path)
-	for _, filePath := range filePaths {
-		if filePath == tarFilePath {
-			continue
-		}
+	if removeFiles {
+		os.RemoveAll(path)
+	}
+	return nil
+}
 
-		err := addFileToTarWriter(filePath, dirOfPath, tarWriter)
-		if err != nil {
-			return fmt.Errorf("failed to add %s to tar writer: %s", filePath, err)
-		}
+// isHidden reports whether the base name of path starts with a dot.
+func isHidden(path string) bool {
+	return strings.HasPrefix(filepath.Base(path), ".")
+}
 
-		if removeFiles {
-			os.Remove(filePath)
-		}
+// makeHeaderDeterministic strips every source of run-to-run variance from
+// header so that archiving the same tree twice produces byte-identical
+// output: timestamps are clamped to epoch, ownership is zeroed, and mode
+// bits are set to 0755 (directories) or 0644 (regular files). Other entry
+// types, such as symlinks and device files, keep their mode bits.
+func makeHeaderDeterministic(header *tar.Header, epoch time.Time) {
+	header.ModTime = epoch
+	header.AccessTime = time.Time{}
+	header.ChangeTime = time.Time{}
+	header.Uid = 0
+	header.Gid = 0
+	header.Uname = ""
+	header.Gname = ""
+	if header.Typeflag == tar.TypeDir {
+		header.Mode = 0755
+	} else if header.Typeflag == tar.TypeReg {
+		header.Mode = 0644
 	}
-	return nil
 }
 
 // Get all file paths in the tarball that match the given regex.
@@ -117,13 +189,14 @@ func GetFilePathsWithRegex(tarFilePath, regex string) ([]string, error) {
 		return nil, err
 	}
 
-	gzipReader, err := gzip.NewReader(file)
+	decompressed, _, err := DecompressStream(file)
 	if err != nil {
 		return nil, err
 	}
+	defer decompressed.Close()
 
 	var files []string
-	tarReader := tar.NewReader(gzipReader)
+	tarReader := tar.NewReader(decompressed)
 	for {
 		header, err := tarReader.Next()
 		if err != nil {
@@ -140,88 +213,94 @@ func GetFilePathsWithRegex(tarFilePath, regex string) ([]string, error) {
 	}
 }
 
+// ReadFileFromGzippedTarball reads the full contents of path out of the
+// gzipped tarball held in data. It buffers the whole tarball in memory; for
+// large bundles use ReadFileFromTarballStream instead.
 func ReadFileFromGzippedTarball(data []byte, path string) ([]byte, error) {
-	buf := bytes.NewBuffer(data)
-	gzipReader, err := gzip.NewReader(buf)
+	rc, err := ReadFileFromTarballStream(bytes.NewBuffer(data), path)
 	if err != nil {
 		return nil, err
 	}
-	defer gzipReader.Close()
-
-	tarReader := tar.NewReader(gzipReader)
-	for {
-		header, err := tarReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				err = fmt.Errorf("no file named %s in tarball", path)
-			}
-			return nil, err
-		}
-		if header.Name == path {
-			return ioutil.ReadAll(tarReader)
-		}
-	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
 }
 
+// WriteTarballToTarWriter re-emits the entries of the gzipped tarball held
+// in data into tarWriter, trimming pathPrefixToTrim from each entry's name.
+// It buffers the whole tarball in memory; for large bundles use
+// WriteTarballStreamToTarWriter instead.
 func WriteTarballToTarWriter(data []byte, tarWriter *tar.Writer, pathPrefixToTrim string) error {
-	gzipReader, err := gzip.NewReader(bytes.NewBuffer(data))
+	return WriteTarballStreamToTarWriter(bytes.NewBuffer(data), tarWriter, pathPrefixToTrim)
+}
+
+// WriteTarballToTarWriterWithRedactors is WriteTarballToTarWriter with an
+// optional set of Redactors applied to every regular file's content as it's
+// copied over.
+func WriteTarballToTarWriterWithRedactors(data []byte, tarWriter *tar.Writer, pathPrefixToTrim string, redactors []Redactor) error {
+	return WriteTarballStreamToTarWriterWithRedactors(bytes.NewBuffer(data), tarWriter, pathPrefixToTrim, redactors)
+}
+
+func addFileToTarWriter(filePath, dirPath string, tarWriter *tar.Writer, opts WalkOptions) error {
+	info, err := os.Lstat(filePath)
 	if err != nil {
 		return err
 	}
-	defer gzipReader.Close()
 
-	tarReader := tar.NewReader(gzipReader)
-	for {
-		header, err := tarReader.Next()
-		if err != nil {
-			if err == io.EOF {
-				return nil
+	contentPath := filePath
+	var linkTarget string
+	if info.Mode()&os.ModeSymlink != 0 {
+		if opts.Dereference {
+			target, err := filepath.EvalSymlinks(filePath)
+			if err != nil {
+				return err
+			}
+			if info, err = os.Stat(target); err != nil {
+				return err
+			}
+			contentPath = target
+		} else {
+			if linkTarget, err = os.Readlink(filePath); err != nil {
+				return err
 			}
-			return err
-		}
-
-		if pathPrefixToTrim != "" {
-			header.Name = strings.TrimPrefix(header.Name, pathPrefixToTrim)
-		}
-
-		if err = tarWriter.WriteHeader(header); err != nil {
-			return err
-		}
-
-		if _, err = io.Copy(tarWriter, tarReader); err != nil {
-			return err
 		}
 	}
-}
 
-func addFileToTarWriter(filePath, dirPath string, tarWriter *tar.Writer) error {
-	file, err := os.Open(filePath)
+	relFilePath, err := filepath.Rel(dirPath, filePath)
 	if err != nil {
 		return err
 	}
-	defer file.Close()
 
-	info, err := file.Stat()
+	header, err := tar.FileInfoHeader(info, linkTarget)
 	if err != nil {
 		return err
 	}
-
-	relFilePath, err := filepath.Rel(dirPath, filePath)
-	if err != nil {
-		return err
+	header.Name = filepath.ToSlash(relFilePath)
+	if info.IsDir() {
+		header.Name += "/"
 	}
+	if opts.PathPrefix != "" {
+		header.Name = filepath.ToSlash(filepath.Join(opts.PathPrefix, header.Name))
+	}
+
+	header.Uid, header.Gid, header.Uname, header.Gname = fileOwner(info)
 
-	header := &tar.Header{
-		Name:    relFilePath,
-		Size:    info.Size(),
-		Mode:    int64(info.Mode()),
-		ModTime: info.ModTime(),
+	if opts.Deterministic {
+		makeHeaderDeterministic(header, opts.Epoch)
 	}
 
 	if err = tarWriter.WriteHeader(header); err != nil {
 		return err
 	}
 
-	_, err = io.Copy(tarWriter, file)
-	return err
+	if info.Mode().IsRegular() {
+		file, err := os.Open(contentPath)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		if _, err = io.Copy(tarWriter, file); err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,51 @@
+package tarball
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCreateGzippedTarballWithOptionsDeterministic(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "tarball-src-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	if err := os.MkdirAll(filepath.Join(srcDir, "sub"), 0755); err != nil {
+		t.Fatalf("failed to create subdir: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "a.txt"), []byte("hello"), 0644); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(srcDir, "sub", "b.txt"), []byte("world"), 0600); err != nil {
+		t.Fatalf("failed to write file: %s", err)
+	}
+
+	opts := WalkOptions{Deterministic: true, Epoch: time.Unix(1700000000, 0).UTC()}
+
+	tarFilePath := filepath.Join(os.TempDir(), "tarball-deterministic-test.tar.gz")
+
+	hashes := make([][32]byte, 2)
+	for i := range hashes {
+		if err := CreateGzippedTarballWithOptions(tarFilePath, srcDir, false, opts); err != nil {
+			t.Fatalf("failed to create tarball: %s", err)
+		}
+
+		data, err := ioutil.ReadFile(tarFilePath)
+		if err != nil {
+			t.Fatalf("failed to read tarball: %s", err)
+		}
+		os.Remove(tarFilePath)
+
+		hashes[i] = sha256.Sum256(data)
+	}
+
+	if hashes[0] != hashes[1] {
+		t.Fatalf("deterministic tarballs differ: %x != %x", hashes[0], hashes[1])
+	}
+}
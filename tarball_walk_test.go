@@ -0,0 +1,77 @@
+package tarball
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateGzippedTarballWithOptionsDereferenceKeepsSymlinkName(t *testing.T) {
+	srcDir, err := ioutil.TempDir("", "tarball-src-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(srcDir)
+
+	outsideDir, err := ioutil.TempDir("", "tarball-outside-*")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(outsideDir)
+
+	target := filepath.Join(outsideDir, "secret.txt")
+	if err := ioutil.WriteFile(target, []byte("shh"), 0644); err != nil {
+		t.Fatalf("failed to write target file: %s", err)
+	}
+	link := filepath.Join(srcDir, "link.txt")
+	if err := os.Symlink(target, link); err != nil {
+		t.Fatalf("failed to create symlink: %s", err)
+	}
+
+	tarFilePath := filepath.Join(os.TempDir(), "tarball-dereference-test.tar.gz")
+	defer os.Remove(tarFilePath)
+
+	if err := CreateGzippedTarballWithOptions(tarFilePath, srcDir, false, WalkOptions{Dereference: true}); err != nil {
+		t.Fatalf("failed to create tarball: %s", err)
+	}
+
+	data, err := ioutil.ReadFile(tarFilePath)
+	if err != nil {
+		t.Fatalf("failed to read tarball: %s", err)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("failed to open gzip reader: %s", err)
+	}
+	defer gzipReader.Close()
+
+	tarReader := tar.NewReader(gzipReader)
+	var names []string
+	for {
+		header, err := tarReader.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+
+	wantSuffix := "/link.txt"
+	found := false
+	for _, name := range names {
+		if strings.HasSuffix(name, wantSuffix) {
+			found = true
+		}
+		if strings.Contains(name, "..") {
+			t.Fatalf("entry name %q escapes the archive root", name)
+		}
+	}
+	if !found {
+		t.Fatalf("expected an entry ending in %q, got entries %v", wantSuffix, names)
+	}
+}